@@ -0,0 +1,157 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// StateOverride replaces the storage slots of a single account for the
+// duration of a CallMessageBundle, letting a client preview a call against
+// hypothetical state (e.g. a token balance it doesn't actually hold yet).
+//
+// Not yet wired up: the underlying CallMessage executor has no override
+// hook, so CallMessageBundle rejects any non-empty StateOverride rather
+// than silently ignoring it. See BlockContext for the same caveat.
+type StateOverride struct {
+	Address common.Address              `json:"address"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// BlockContext pins the block a bundle is simulated against, so every call
+// in it sees the same number and timestamp.
+//
+// Not yet wired up: see StateOverride.
+type BlockContext struct {
+	BlockNumber *big.Int `json:"blockNumber,omitempty"`
+	Timestamp   *big.Int `json:"timestamp,omitempty"`
+}
+
+// CallMessageBundleArgs is an ordered list of calls to run one after
+// another through CallMessage. StateOverrides[i], if present, holds the
+// overrides for Calls[i]; it may be shorter than Calls, in which case the
+// remaining calls get no overrides. CallMessageBundle rejects the whole
+// bundle with ErrCallMessageBundleOverridesUnsupported if any entry is
+// non-empty.
+type CallMessageBundleArgs struct {
+	Calls            []CallMessageArgs `json:"calls"`
+	StateOverrides   [][]StateOverride `json:"stateOverrides,omitempty"`
+	BlockContext     *BlockContext     `json:"blockContext,omitempty"`
+	ContinueOnRevert bool              `json:"continueOnRevert"`
+}
+
+// CallMessageBundleResult is the outcome of one call within a bundle. Only
+// calls that ran and came back with a reply get a result; a call that
+// never ran, because the bundle was aborted before it, has no entry.
+type CallMessageBundleResult struct {
+	Reply    *CallMessageReply `json:"reply,omitempty"`
+	Reverted bool              `json:"reverted"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// CallMessageBundleReply is the aggregate result of a bundle: the
+// per-call results in the order they were submitted, plus the gas the
+// whole bundle would have used and the net state diff it would have left
+// behind.
+type CallMessageBundleReply struct {
+	Results   []CallMessageBundleResult                      `json:"results"`
+	GasUsed   uint64                                         `json:"gasUsed"`
+	StateDiff map[common.Address]map[common.Hash]common.Hash `json:"stateDiff,omitempty"`
+}
+
+// ErrCallMessageBundleOverridesUnsupported is returned by CallMessageBundle
+// when the caller sets BlockContext or a StateOverride. CallMessage's
+// executor doesn't accept either yet, so honoring them would mean quietly
+// running every call against plain default state regardless of what the
+// client asked for -- better to fail loudly than to pretend they worked.
+var ErrCallMessageBundleOverridesUnsupported = errors.New("rollupvalidator: CallMessageBundle does not yet support BlockContext or StateOverrides")
+
+// CallMessageBundle runs args.Calls in order, each through its own
+// CallMessage. Every call is executed on its own, so it does not see state
+// changes made by the calls before it. A revert aborts the bundle unless
+// ContinueOnRevert is set, in which case the remaining calls still run; a
+// reverted call's gas and state diff are not added to the reply's totals.
+func (d *CallDispatcher) CallMessageBundle(ctx context.Context, args *CallMessageBundleArgs) (*CallMessageBundleReply, error) {
+	ret, err := d.dispatch(ctx, "CallMessageBundle", args, func(ctx context.Context) (interface{}, error) {
+		return d.runBundle(ctx, args)
+	})
+	if ret == nil {
+		return nil, err
+	}
+	return ret.(*CallMessageBundleReply), err
+}
+
+func (d *CallDispatcher) runBundle(ctx context.Context, args *CallMessageBundleArgs) (*CallMessageBundleReply, error) {
+	if args.BlockContext != nil {
+		return nil, ErrCallMessageBundleOverridesUnsupported
+	}
+	for _, overrides := range args.StateOverrides {
+		if len(overrides) > 0 {
+			return nil, ErrCallMessageBundleOverridesUnsupported
+		}
+	}
+
+	reply := &CallMessageBundleReply{
+		Results:   make([]CallMessageBundleResult, 0, len(args.Calls)),
+		StateDiff: make(map[common.Address]map[common.Hash]common.Hash),
+	}
+
+	for i := range args.Calls {
+		call := args.Calls[i]
+		ret, err := d.Server.CallMessage(ctx, &call)
+		switch {
+		case err != nil && ret == nil:
+			// CallMessage produced no reply at all -- a canceled context, a
+			// database error, bad args -- which is an infrastructure
+			// failure, not a revert. Abort the whole bundle rather than
+			// guessing at what state a call that never ran would have left.
+			return reply, err
+
+		case err != nil:
+			// The call ran far enough to come back with a reply describing
+			// why it reverted. That's a normal, expected bundle outcome
+			// (like eth_callBundle), not a dispatch error, so it doesn't
+			// abort CallMessageBundle itself.
+			reply.Results = append(reply.Results, CallMessageBundleResult{
+				Reply:    ret,
+				Reverted: true,
+				Error:    err.Error(),
+			})
+			if !args.ContinueOnRevert {
+				return reply, nil
+			}
+
+		default:
+			reply.Results = append(reply.Results, CallMessageBundleResult{Reply: ret})
+			reply.GasUsed += ret.GasUsed
+			for addr, slots := range ret.StateDiff {
+				if reply.StateDiff[addr] == nil {
+					reply.StateDiff[addr] = make(map[common.Hash]common.Hash)
+				}
+				for slot, value := range slots {
+					reply.StateDiff[addr][slot] = value
+				}
+			}
+		}
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// grpcServer implements RollupValidatorServer (generated by the
+// //go:generate protoc directive in rpcserver.go) directly against the protobuf
+// FindLogsArgs/FindLogsReply/... types, so calls never round-trip through
+// JSON the way RPCServer's do. It shares its CallDispatcher with RPCServer
+// and the JSON-RPC server, so middleware registered with
+// UseCallRpcMiddleware runs for gRPC calls too, and shares its
+// SubscriptionManager with the websocket transport, so the rollup watcher
+// only has one NotifyNewAssertion to call to keep both in sync.
+type grpcServer struct {
+	UnimplementedRollupValidatorServer
+
+	*CallDispatcher
+
+	subscriptions *SubscriptionManager
+}
+
+// NewGRPCServer returns a *grpc.Server with RollupValidatorServer
+// registered against dispatcher, plus gRPC reflection and health-check
+// services so tools like grpcurl and rollup-aware indexers can talk to it
+// without a JSON adapter. subscriptions is the same SubscriptionManager
+// the websocket transport uses, so SubscribeAssertions streams are fed by
+// the one NotifyNewAssertion fan-out.
+func NewGRPCServer(dispatcher *CallDispatcher, subscriptions *SubscriptionManager) *grpc.Server {
+	srv := grpc.NewServer()
+	RegisterRollupValidatorServer(srv, &grpcServer{
+		CallDispatcher: dispatcher,
+		subscriptions:  subscriptions,
+	})
+
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("rollupvalidator.RollupValidator", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(srv, healthSrv)
+
+	reflection.Register(srv)
+
+	return srv
+}
+
+// FindLogs sends each log in the matching FindLogsReply to the client as a
+// separate stream message. The underlying CallDispatcher.FindLogs call
+// itself is not incremental -- it blocks until the full set of matching
+// logs has been collected -- so this only streams the response, not the
+// search.
+func (s *grpcServer) FindLogs(args *FindLogsArgs, stream RollupValidator_FindLogsServer) error {
+	ret, err := s.CallDispatcher.FindLogs(stream.Context(), args)
+	if err != nil {
+		return err
+	}
+	for _, log := range ret.Logs {
+		if err := stream.Send(log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMessageResult returns the value output by the VM in response to the message with the given hash
+func (s *grpcServer) GetMessageResult(ctx context.Context, args *GetMessageResultArgs) (*GetMessageResultReply, error) {
+	return s.CallDispatcher.GetMessageResult(ctx, args)
+}
+
+// GetAssertionCount returns the total number of finalized assertions
+func (s *grpcServer) GetAssertionCount(ctx context.Context, args *GetAssertionCountArgs) (*GetAssertionCountReply, error) {
+	return s.CallDispatcher.GetAssertionCount(ctx, args)
+}
+
+// GetVMInfo returns current metadata about this VM
+func (s *grpcServer) GetVMInfo(ctx context.Context, args *GetVMInfoArgs) (*GetVMInfoReply, error) {
+	return s.CallDispatcher.GetVMInfo(ctx, args)
+}
+
+// CallMessage takes a request from a client to process in a temporary context and return the result
+func (s *grpcServer) CallMessage(ctx context.Context, args *CallMessageArgs) (*CallMessageReply, error) {
+	return s.CallDispatcher.CallMessage(ctx, args)
+}
+
+// CallMessageBundle takes a request from a client to process in a
+// temporary context and return the result
+func (s *grpcServer) CallMessageBundle(ctx context.Context, args *CallMessageBundleArgs) (*CallMessageBundleReply, error) {
+	return s.CallDispatcher.CallMessageBundle(ctx, args)
+}
+
+// SubscribeAssertions streams a GetAssertionCountReply to the client each
+// time a new assertion is finalized, until the client disconnects. It is
+// fed by the same SubscriptionManager the websocket "newAssertions"
+// subscribers use, via NotifyNewAssertion.
+func (s *grpcServer) SubscribeAssertions(_ *GetAssertionCountArgs, stream RollupValidator_SubscribeAssertionsServer) error {
+	ch, cancel, err := s.subscriptions.SubscribeAssertions()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for {
+		select {
+		case result := <-ch:
+			info, ok := result.(GetAssertionCountReply)
+			if !ok {
+				continue
+			}
+			if err := stream.Send(&info); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"context"
+	"net"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// jsonrpcService exposes a CallDispatcher's methods for registration with
+// go-ethereum's reflection-based JSON-RPC 2.0 server. Each method keeps the
+// context.Context that rpc passes in from the transport instead of
+// substituting context.Background(), so a client that drops an HTTP
+// connection, closes a websocket, or cancels a batched call actually
+// cancels the underlying validator/database work.
+type jsonrpcService struct {
+	*CallDispatcher
+}
+
+// NewJSONRPCServer returns a *rpc.Server with every RPCServer method
+// registered under the "rollup" namespace (e.g. "rollup_findLogs"). The
+// returned server understands HTTP, WebSocket, and IPC transports and
+// request batching for free, and can be registered alongside RPCServer
+// without duplicating any handler logic, since both wrappers simply
+// forward to the same dispatcher -- including whatever middleware has been
+// registered on it.
+func NewJSONRPCServer(dispatcher *CallDispatcher) (*rpc.Server, error) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("rollup", &jsonrpcService{dispatcher}); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// FindLogs takes a set of parameters and return the list of all logs that match the query
+func (s *jsonrpcService) FindLogs(ctx context.Context, args *FindLogsArgs) (*FindLogsReply, error) {
+	return s.CallDispatcher.FindLogs(ctx, args)
+}
+
+// GetMessageResult returns the value output by the VM in response to the message with the given hash
+func (s *jsonrpcService) GetMessageResult(ctx context.Context, args *GetMessageResultArgs) (*GetMessageResultReply, error) {
+	return s.CallDispatcher.GetMessageResult(ctx, args)
+}
+
+// GetAssertionCount returns the total number of finalized assertions
+func (s *jsonrpcService) GetAssertionCount(ctx context.Context, args *GetAssertionCountArgs) (*GetAssertionCountReply, error) {
+	return s.CallDispatcher.GetAssertionCount(ctx, args)
+}
+
+// GetVMInfo returns current metadata about this VM
+func (s *jsonrpcService) GetVMInfo(ctx context.Context, args *GetVMInfoArgs) (*GetVMInfoReply, error) {
+	return s.CallDispatcher.GetVMInfo(ctx, args)
+}
+
+// CallMessage takes a request from a client to process in a temporary context and return the result
+func (s *jsonrpcService) CallMessage(ctx context.Context, args *CallMessageArgs) (*CallMessageReply, error) {
+	return s.CallDispatcher.CallMessage(ctx, args)
+}
+
+// CallMessageBundle takes a request from a client to process in a
+// temporary context and return the result
+func (s *jsonrpcService) CallMessageBundle(ctx context.Context, args *CallMessageBundleArgs) (*CallMessageBundleReply, error) {
+	return s.CallDispatcher.CallMessageBundle(ctx, args)
+}
+
+// ServeIPC listens on the unix socket (or named pipe, on Windows) at
+// endpoint and services JSON-RPC 2.0 requests over it until the returned
+// listener is closed.
+func ServeIPC(srv *rpc.Server, endpoint string) (net.Listener, error) {
+	listener, err := rpc.CreateIPCListener(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	go srv.ServeListener(listener)
+	return listener, nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import "context"
+
+// CallInfo describes a single RPC invocation as it passes through the
+// middleware chain: the method being called and the args it was called
+// with.
+type CallInfo struct {
+	Method string
+	Args   interface{}
+}
+
+// Next is called by a Middleware to continue the chain, ultimately
+// invoking the underlying Server method and returning its reply and error.
+type Next func(ctx context.Context) (interface{}, error)
+
+// Middleware wraps a single RPC call. It receives the call's CallInfo and a
+// Next that runs the rest of the chain; it may inspect or replace the
+// context, short-circuit by not calling next, and inspect or replace the
+// reply and error that next returns. This is where logging, Prometheus
+// metrics, rate limiting, auth, caching, and tracing should hook in.
+type Middleware func(ctx context.Context, call CallInfo, next Next) (interface{}, error)
+
+// CallDispatcher wraps a Server with a composable middleware chain and
+// re-exposes its RPC entry points (FindLogs, GetMessageResult,
+// GetAssertionCount, GetVMInfo, CallMessage) so every transport --
+// RPCServer's Gorilla shim and the JSON-RPC server alike -- runs calls
+// through the same chain instead of hitting the Server directly.
+type CallDispatcher struct {
+	*Server
+
+	middleware []Middleware
+}
+
+// NewCallDispatcher returns a CallDispatcher with an empty middleware
+// chain wrapping server.
+func NewCallDispatcher(server *Server) *CallDispatcher {
+	return &CallDispatcher{Server: server}
+}
+
+// UseCallRpcMiddleware appends mw to the chain. Middleware registered this
+// way runs, in registration order from outermost in, for every call made
+// through this dispatcher.
+func (d *CallDispatcher) UseCallRpcMiddleware(mw Middleware) {
+	d.middleware = append(d.middleware, mw)
+}
+
+// dispatch runs call through the dispatcher's middleware chain before
+// invoking terminal, the underlying Server method.
+func (d *CallDispatcher) dispatch(ctx context.Context, method string, args interface{}, terminal Next) (interface{}, error) {
+	next := terminal
+	for i := len(d.middleware) - 1; i >= 0; i-- {
+		mw := d.middleware[i]
+		cur := next
+		next = func(ctx context.Context) (interface{}, error) {
+			return mw(ctx, CallInfo{Method: method, Args: args}, cur)
+		}
+	}
+	return next(ctx)
+}
+
+// FindLogs takes a set of parameters and return the list of all logs that match the query
+func (d *CallDispatcher) FindLogs(ctx context.Context, args *FindLogsArgs) (*FindLogsReply, error) {
+	ret, err := d.dispatch(ctx, "FindLogs", args, func(ctx context.Context) (interface{}, error) {
+		return d.Server.FindLogs(ctx, args)
+	})
+	if ret == nil {
+		return nil, err
+	}
+	return ret.(*FindLogsReply), err
+}
+
+// GetMessageResult returns the value output by the VM in response to the message with the given hash
+func (d *CallDispatcher) GetMessageResult(ctx context.Context, args *GetMessageResultArgs) (*GetMessageResultReply, error) {
+	ret, err := d.dispatch(ctx, "GetMessageResult", args, func(ctx context.Context) (interface{}, error) {
+		return d.Server.GetMessageResult(ctx, args)
+	})
+	if ret == nil {
+		return nil, err
+	}
+	return ret.(*GetMessageResultReply), err
+}
+
+// GetAssertionCount returns the total number of finalized assertions
+func (d *CallDispatcher) GetAssertionCount(ctx context.Context, args *GetAssertionCountArgs) (*GetAssertionCountReply, error) {
+	ret, err := d.dispatch(ctx, "GetAssertionCount", args, func(ctx context.Context) (interface{}, error) {
+		return d.Server.GetAssertionCount(ctx, args)
+	})
+	if ret == nil {
+		return nil, err
+	}
+	return ret.(*GetAssertionCountReply), err
+}
+
+// GetVMInfo returns current metadata about this VM
+func (d *CallDispatcher) GetVMInfo(ctx context.Context, args *GetVMInfoArgs) (*GetVMInfoReply, error) {
+	ret, err := d.dispatch(ctx, "GetVMInfo", args, func(ctx context.Context) (interface{}, error) {
+		return d.Server.GetVMInfo(ctx, args)
+	})
+	if ret == nil {
+		return nil, err
+	}
+	return ret.(*GetVMInfoReply), err
+}
+
+// CallMessage takes a request from a client to process in a temporary context and return the result
+func (d *CallDispatcher) CallMessage(ctx context.Context, args *CallMessageArgs) (*CallMessageReply, error) {
+	ret, err := d.dispatch(ctx, "CallMessage", args, func(ctx context.Context) (interface{}, error) {
+		return d.Server.CallMessage(ctx, args)
+	})
+	if ret == nil {
+		return nil, err
+	}
+	return ret.(*CallMessageReply), err
+}
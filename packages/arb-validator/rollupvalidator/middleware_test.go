@@ -0,0 +1,106 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"context"
+	"testing"
+)
+
+func recordingMiddleware(name string, order *[]string) Middleware {
+	return func(ctx context.Context, call CallInfo, next Next) (interface{}, error) {
+		*order = append(*order, name+":before")
+		ret, err := next(ctx)
+		*order = append(*order, name+":after")
+		return ret, err
+	}
+}
+
+func TestDispatchRunsMiddlewareOutermostFirst(t *testing.T) {
+	d := &CallDispatcher{}
+	var order []string
+	d.UseCallRpcMiddleware(recordingMiddleware("outer", &order))
+	d.UseCallRpcMiddleware(recordingMiddleware("inner", &order))
+
+	terminal := func(ctx context.Context) (interface{}, error) {
+		order = append(order, "terminal")
+		return "ok", nil
+	}
+
+	ret, err := d.dispatch(context.Background(), "Test", nil, terminal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret != "ok" {
+		t.Fatalf("got result %v, want %q", ret, "ok")
+	}
+
+	want := []string{"outer:before", "inner:before", "terminal", "inner:after", "outer:after"}
+	if len(order) != len(want) {
+		t.Fatalf("got order %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestDispatchMiddlewareCanShortCircuit(t *testing.T) {
+	d := &CallDispatcher{}
+	terminalCalled := false
+
+	d.UseCallRpcMiddleware(func(ctx context.Context, call CallInfo, next Next) (interface{}, error) {
+		return "short-circuited", nil
+	})
+
+	terminal := func(ctx context.Context) (interface{}, error) {
+		terminalCalled = true
+		return "terminal", nil
+	}
+
+	ret, err := d.dispatch(context.Background(), "Test", nil, terminal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret != "short-circuited" {
+		t.Fatalf("got result %v, want %q", ret, "short-circuited")
+	}
+	if terminalCalled {
+		t.Fatal("terminal should not run once a middleware short-circuits")
+	}
+}
+
+func TestDispatchPassesCallInfoToMiddleware(t *testing.T) {
+	d := &CallDispatcher{}
+	var got CallInfo
+	d.UseCallRpcMiddleware(func(ctx context.Context, call CallInfo, next Next) (interface{}, error) {
+		got = call
+		return next(ctx)
+	})
+
+	args := "some-args"
+	if _, err := d.dispatch(context.Background(), "FindLogs", args, func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Method != "FindLogs" || got.Args != args {
+		t.Fatalf("got CallInfo %+v, want Method=FindLogs Args=%v", got, args)
+	}
+}
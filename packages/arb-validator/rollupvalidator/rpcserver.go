@@ -17,19 +17,23 @@
 package rollupvalidator
 
 import (
-	"context"
-	"github.com/offchainlabs/arbitrum/packages/arb-validator/arbbridge"
 	"net/http"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/offchainlabs/arbitrum/packages/arb-validator/arbbridge"
 	"github.com/offchainlabs/arbitrum/packages/arb-validator/structures"
 )
 
 //go:generate bash -c "protoc -I$(go list -f '{{ .Dir }}' -m github.com/offchainlabs/arbitrum/packages/arb-validator) -I. --tstypes_out=../../arb-provider-ethers/src/lib --go_out=paths=source_relative,plugins=grpc:. *.proto"
-// Server provides an interface for interacting with a a running coordinator
+// RPCServer exposes a Server's CallDispatcher over Gorilla's
+// reflection-based net/rpc shim. NewJSONRPCServer registers the same
+// dispatcher on go-ethereum's JSON-RPC 2.0 server for clients that want
+// HTTP, WebSocket, or IPC transports, context cancellation, or batching;
+// both transports run every call through the dispatcher's middleware
+// chain, so registering a Middleware once covers both.
 type RPCServer struct {
-	*Server
+	*CallDispatcher
 }
 
 // NewServer returns a new instance of the Server class
@@ -41,12 +45,12 @@ func NewRPCServer(
 	config structures.ChainParams,
 ) (*RPCServer, error) {
 	server, err := NewServer(auth, client, rollupAddress, codeFile, config)
-	return &RPCServer{server}, err
+	return &RPCServer{NewCallDispatcher(server)}, err
 }
 
 // FindLogs takes a set of parameters and return the list of all logs that match the query
 func (m *RPCServer) FindLogs(r *http.Request, args *FindLogsArgs, reply *FindLogsReply) error {
-	ret, err := m.Server.FindLogs(context.Background(), args)
+	ret, err := m.CallDispatcher.FindLogs(r.Context(), args)
 	if ret != nil {
 		*reply = *ret
 	}
@@ -55,7 +59,7 @@ func (m *RPCServer) FindLogs(r *http.Request, args *FindLogsArgs, reply *FindLog
 
 // GetMessageResult returns the value output by the VM in response to the message with the given hash
 func (m *RPCServer) GetMessageResult(r *http.Request, args *GetMessageResultArgs, reply *GetMessageResultReply) error {
-	ret, err := m.Server.GetMessageResult(context.Background(), args)
+	ret, err := m.CallDispatcher.GetMessageResult(r.Context(), args)
 	if ret != nil {
 		*reply = *ret
 	}
@@ -64,7 +68,7 @@ func (m *RPCServer) GetMessageResult(r *http.Request, args *GetMessageResultArgs
 
 // GetAssertionCount returns the total number of finalized assertions
 func (m *RPCServer) GetAssertionCount(r *http.Request, args *GetAssertionCountArgs, reply *GetAssertionCountReply) error {
-	ret, err := m.Server.GetAssertionCount(context.Background(), args)
+	ret, err := m.CallDispatcher.GetAssertionCount(r.Context(), args)
 	if ret != nil {
 		*reply = *ret
 	}
@@ -73,7 +77,7 @@ func (m *RPCServer) GetAssertionCount(r *http.Request, args *GetAssertionCountAr
 
 // GetVMInfo returns current metadata about this VM
 func (m *RPCServer) GetVMInfo(r *http.Request, args *GetVMInfoArgs, reply *GetVMInfoReply) error {
-	ret, err := m.Server.GetVMInfo(context.Background(), args)
+	ret, err := m.CallDispatcher.GetVMInfo(r.Context(), args)
 	if ret != nil {
 		*reply = *ret
 	}
@@ -82,7 +86,17 @@ func (m *RPCServer) GetVMInfo(r *http.Request, args *GetVMInfoArgs, reply *GetVM
 
 // CallMessage takes a request from a client to process in a temporary context and return the result
 func (m *RPCServer) CallMessage(r *http.Request, args *CallMessageArgs, reply *CallMessageReply) error {
-	ret, err := m.Server.CallMessage(context.Background(), args)
+	ret, err := m.CallDispatcher.CallMessage(r.Context(), args)
+	if ret != nil {
+		*reply = *ret
+	}
+	return err
+}
+
+// CallMessageBundle takes a request from a client to process in a
+// temporary context and return the result
+func (m *RPCServer) CallMessageBundle(r *http.Request, args *CallMessageBundleArgs, reply *CallMessageBundleReply) error {
+	ret, err := m.CallDispatcher.CallMessageBundle(r.Context(), args)
 	if ret != nil {
 		*reply = *ret
 	}
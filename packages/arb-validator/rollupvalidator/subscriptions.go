@@ -0,0 +1,446 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteWait bounds how long a single websocket write may block. Without
+// it, a client that stops reading (a wedged tab, a dead connection the TCP
+// stack hasn't noticed yet) would stall writeJSON forever, and since
+// notify fans out to every subscriber in turn, that one client would stall
+// delivery to all the others too.
+const wsWriteWait = 10 * time.Second
+
+// subscriptionKind identifies the class of event a client has subscribed to
+type subscriptionKind string
+
+const (
+	logsSubscription          subscriptionKind = "logs"
+	newAssertionsSubscription subscriptionKind = "newAssertions"
+)
+
+// SubscriptionID uniquely identifies a live subscription on a single connection
+type SubscriptionID string
+
+// subscription tracks a single client's interest in a stream of events and
+// where notifications for it should be delivered
+type subscription struct {
+	id     SubscriptionID
+	kind   subscriptionKind
+	filter *FindLogsArgs
+	sink   notifySink
+}
+
+// notifySink delivers a single notification result to whatever transport a
+// subscription was created on. This is what lets SubscriptionManager be
+// the one place the rollup watcher calls into (NotifyLogs,
+// NotifyNewAssertion) while still fanning out to websocket clients and
+// gRPC streams alike.
+type notifySink interface {
+	deliver(sub SubscriptionID, result interface{}) error
+}
+
+// wsConn serializes writes to a single websocket connection, since
+// notifications can be produced concurrently from multiple goroutines
+type wsConn struct {
+	sync.Mutex
+	ws *websocket.Conn
+}
+
+func (c *wsConn) writeJSON(v interface{}) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+		return err
+	}
+	return c.ws.WriteJSON(v)
+}
+
+// deliver satisfies notifySink by pushing an eth_subscription-style JSON-RPC
+// notification over the websocket connection.
+func (c *wsConn) deliver(sub SubscriptionID, result interface{}) error {
+	params, err := json.Marshal(&subscriptionNotification{
+		Subscription: sub,
+		Result:       result,
+	})
+	if err != nil {
+		return err
+	}
+	return c.writeJSON(&rpcMessage{
+		Version: "2.0",
+		Method:  "eth_subscription",
+		Params:  params,
+	})
+}
+
+// chanSink satisfies notifySink for subscribers, such as gRPC streams, that
+// want results delivered on a Go channel instead of a websocket. Delivery
+// is non-blocking: a subscriber that isn't keeping up drops the
+// notification rather than stalling the rest of the fan-out.
+type chanSink struct {
+	ch chan interface{}
+}
+
+func (s chanSink) deliver(_ SubscriptionID, result interface{}) error {
+	select {
+	case s.ch <- result:
+	default:
+	}
+	return nil
+}
+
+// SubscriptionManager fans out assertion and log events produced by the
+// rollup watcher to the subscribers registered for them
+type SubscriptionManager struct {
+	*Server
+
+	upgrader websocket.Upgrader
+
+	mu   sync.Mutex
+	subs map[SubscriptionID]*subscription
+}
+
+// NewSubscriptionManager returns a SubscriptionManager that serves pub/sub
+// requests over the given Server
+func NewSubscriptionManager(server *Server) *SubscriptionManager {
+	return &SubscriptionManager{
+		Server: server,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		subs: make(map[SubscriptionID]*subscription),
+	}
+}
+
+// rpcMessage is a minimal JSON-RPC 2.0 envelope, used here only for the
+// subscribe/unsubscribe control messages and their notifications
+type rpcMessage struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// subscriptionNotification is pushed to a client for each matching event on
+// a subscription it holds
+type subscriptionNotification struct {
+	Subscription SubscriptionID `json:"subscription"`
+	Result       interface{}    `json:"result"`
+}
+
+// ServeWS upgrades the connection to a websocket and services eth_subscribe
+// and eth_unsubscribe requests for its lifetime, tearing down any
+// subscriptions the client created when the connection closes
+func (m *SubscriptionManager) ServeWS(w http.ResponseWriter, r *http.Request) {
+	ws, err := m.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("failed to upgrade websocket connection:", err)
+		return
+	}
+	conn := &wsConn{ws: ws}
+	defer m.closeConn(conn)
+
+	for {
+		var req rpcMessage
+		if err := ws.ReadJSON(&req); err != nil {
+			return
+		}
+		m.handleMessage(conn, &req)
+	}
+}
+
+func (m *SubscriptionManager) handleMessage(conn *wsConn, req *rpcMessage) {
+	switch req.Method {
+	case "eth_subscribe":
+		m.handleSubscribe(conn, req)
+	case "eth_unsubscribe":
+		m.handleUnsubscribe(conn, req)
+	default:
+		m.reply(conn, req.ID, nil, &rpcError{Code: -32601, Message: "method not found"})
+	}
+}
+
+// handleSubscribe services an eth_subscribe call, whose params are a
+// positional array: ["logs", {filter...}] or ["newAssertions"], matching
+// the wire format go-ethereum clients already speak.
+func (m *SubscriptionManager) handleSubscribe(conn *wsConn, req *rpcMessage) {
+	var params []json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		m.reply(conn, req.ID, nil, &rpcError{Code: -32602, Message: "invalid params"})
+		return
+	}
+
+	var kind subscriptionKind
+	if err := json.Unmarshal(params[0], &kind); err != nil {
+		m.reply(conn, req.ID, nil, &rpcError{Code: -32602, Message: "invalid params"})
+		return
+	}
+	if kind != logsSubscription && kind != newAssertionsSubscription {
+		m.reply(conn, req.ID, nil, &rpcError{Code: -32602, Message: "unknown subscription kind"})
+		return
+	}
+
+	var filter *FindLogsArgs
+	if kind == logsSubscription && len(params) > 1 {
+		filter = &FindLogsArgs{}
+		if err := json.Unmarshal(params[1], filter); err != nil {
+			m.reply(conn, req.ID, nil, &rpcError{Code: -32602, Message: "invalid params"})
+			return
+		}
+	}
+
+	id, err := newSubscriptionID()
+	if err != nil {
+		m.reply(conn, req.ID, nil, &rpcError{Code: -32603, Message: "internal error"})
+		return
+	}
+
+	sub := &subscription{
+		id:     id,
+		kind:   kind,
+		filter: filter,
+		sink:   conn,
+	}
+
+	m.mu.Lock()
+	m.subs[id] = sub
+	m.mu.Unlock()
+
+	m.reply(conn, req.ID, id, nil)
+}
+
+// handleUnsubscribe services an eth_unsubscribe call, whose params are a
+// positional array holding the single subscription id: ["<id>"].
+func (m *SubscriptionManager) handleUnsubscribe(conn *wsConn, req *rpcMessage) {
+	var params []SubscriptionID
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) != 1 {
+		m.reply(conn, req.ID, nil, &rpcError{Code: -32602, Message: "invalid params"})
+		return
+	}
+	id := params[0]
+
+	m.mu.Lock()
+	_, ok := m.subs[id]
+	delete(m.subs, id)
+	m.mu.Unlock()
+
+	m.reply(conn, req.ID, ok, nil)
+}
+
+func (m *SubscriptionManager) closeConn(conn *wsConn) {
+	_ = conn.ws.Close()
+
+	m.mu.Lock()
+	for id, sub := range m.subs {
+		if sub.sink == conn {
+			delete(m.subs, id)
+		}
+	}
+	m.mu.Unlock()
+}
+
+func (m *SubscriptionManager) reply(conn *wsConn, id json.RawMessage, result interface{}, rpcErr *rpcError) {
+	msg := &rpcMessage{Version: "2.0", ID: id, Result: result, Error: rpcErr}
+	if err := conn.writeJSON(msg); err != nil {
+		log.Println("failed to write websocket reply:", err)
+	}
+}
+
+// NotifyLogs fans out each log to every subscription whose filter matches
+// it. It should be called by the rollup watcher whenever a finalized
+// assertion produces new logs.
+func (m *SubscriptionManager) NotifyLogs(logs []LogEvent) {
+	m.mu.Lock()
+	subs := m.logSubscriptions()
+	m.mu.Unlock()
+
+	for _, sub := range subs {
+		for _, l := range logs {
+			if !logMatchesFilter(l, sub.filter) {
+				continue
+			}
+			m.notify(sub, l)
+		}
+	}
+}
+
+// NotifyNewAssertion fans out info to every subscription watching for new
+// finalized assertions.
+func (m *SubscriptionManager) NotifyNewAssertion(info GetAssertionCountReply) {
+	m.mu.Lock()
+	subs := m.newAssertionSubscriptions()
+	m.mu.Unlock()
+
+	for _, sub := range subs {
+		m.notify(sub, info)
+	}
+}
+
+// logSubscriptions returns a snapshot of the current logs subscriptions.
+// Callers must hold m.mu.
+func (m *SubscriptionManager) logSubscriptions() []*subscription {
+	subs := make([]*subscription, 0, len(m.subs))
+	for _, sub := range m.subs {
+		if sub.kind == logsSubscription {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// newAssertionSubscriptions returns a snapshot of the current
+// newAssertions subscriptions. Callers must hold m.mu.
+func (m *SubscriptionManager) newAssertionSubscriptions() []*subscription {
+	subs := make([]*subscription, 0, len(m.subs))
+	for _, sub := range m.subs {
+		if sub.kind == newAssertionsSubscription {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+func (m *SubscriptionManager) notify(sub *subscription, result interface{}) {
+	if err := sub.sink.deliver(sub.id, result); err != nil {
+		log.Println("failed to deliver subscription notification:", err)
+	}
+}
+
+// SubscribeAssertions registers interest in new finalized assertions and
+// returns a channel that receives a GetAssertionCountReply each time
+// NotifyNewAssertion is called, plus a function to tear the subscription
+// down. This is the entry point non-websocket transports, such as the
+// gRPC server's SubscribeAssertions RPC, use to share this fan-out with
+// eth_subscribe clients instead of keeping their own registry.
+func (m *SubscriptionManager) SubscribeAssertions() (<-chan interface{}, func(), error) {
+	id, err := newSubscriptionID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan interface{}, 1)
+	sub := &subscription{
+		id:   id,
+		kind: newAssertionsSubscription,
+		sink: chanSink{ch: ch},
+	}
+
+	m.mu.Lock()
+	m.subs[id] = sub
+	m.mu.Unlock()
+
+	cancel := func() {
+		m.mu.Lock()
+		delete(m.subs, id)
+		m.mu.Unlock()
+	}
+	return ch, cancel, nil
+}
+
+// LogEvent is a single log produced by a finalized assertion, as delivered
+// to "logs" subscribers. It mirrors one entry of a FindLogsReply.
+type LogEvent struct {
+	Log types.Log `json:"log"`
+}
+
+// Matches reports whether log satisfies args, using the same semantics as
+// eth_getLogs/eth_newFilter: Address, if non-empty, must contain the log's
+// emitting contract; Topics, if non-empty, is matched position-by-position
+// against log.Topics, where an empty entry at a position matches any topic
+// there and a non-empty entry must contain log.Topics[i]; and the log's
+// block number must fall within [FromHeight, ToHeight] for whichever bound
+// is set.
+func (args *FindLogsArgs) Matches(log types.Log) bool {
+	if len(args.Address) > 0 {
+		found := false
+		for _, addr := range args.Address {
+			if addr == log.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(args.Topics) > len(log.Topics) {
+		return false
+	}
+	for i, wanted := range args.Topics {
+		if len(wanted) == 0 {
+			continue
+		}
+		found := false
+		for _, topic := range wanted {
+			if topic == log.Topics[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	height := new(big.Int).SetUint64(log.BlockNumber)
+	if args.FromHeight != nil && height.Cmp(args.FromHeight) < 0 {
+		return false
+	}
+	if args.ToHeight != nil && height.Cmp(args.ToHeight) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// logMatchesFilter reports whether log matches the (optional) filter a
+// subscriber registered; a nil filter matches everything.
+func logMatchesFilter(log LogEvent, filter *FindLogsArgs) bool {
+	if filter == nil {
+		return true
+	}
+	return filter.Matches(log.Log)
+}
+
+func newSubscriptionID() (SubscriptionID, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", errors.New("failed to generate subscription id")
+	}
+	return SubscriptionID(hex.EncodeToString(buf[:])), nil
+}
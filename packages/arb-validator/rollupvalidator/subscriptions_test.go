@@ -0,0 +1,139 @@
+/*
+ * Copyright 2019-2020, Offchain Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollupvalidator
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type testSink struct {
+	results []interface{}
+}
+
+func (s *testSink) deliver(_ SubscriptionID, result interface{}) error {
+	s.results = append(s.results, result)
+	return nil
+}
+
+func newSubscriptionManager() *SubscriptionManager {
+	return &SubscriptionManager{subs: make(map[SubscriptionID]*subscription)}
+}
+
+func TestNotifyNewAssertionOnlyReachesMatchingKind(t *testing.T) {
+	m := newSubscriptionManager()
+	assertionSink := &testSink{}
+	logSink := &testSink{}
+	m.subs["a"] = &subscription{id: "a", kind: newAssertionsSubscription, sink: assertionSink}
+	m.subs["b"] = &subscription{id: "b", kind: logsSubscription, sink: logSink}
+
+	m.NotifyNewAssertion(GetAssertionCountReply{})
+
+	if len(assertionSink.results) != 1 {
+		t.Fatalf("got %d notifications on the assertions sink, want 1", len(assertionSink.results))
+	}
+	if len(logSink.results) != 0 {
+		t.Fatalf("got %d notifications on the logs sink, want 0", len(logSink.results))
+	}
+}
+
+func TestNotifyLogsOnlyReachesMatchingFilter(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	other := common.HexToAddress("0x2")
+
+	m := newSubscriptionManager()
+	matching := &testSink{}
+	nonMatching := &testSink{}
+	m.subs["a"] = &subscription{id: "a", kind: logsSubscription, filter: &FindLogsArgs{Address: []common.Address{addr}}, sink: matching}
+	m.subs["b"] = &subscription{id: "b", kind: logsSubscription, filter: &FindLogsArgs{Address: []common.Address{other}}, sink: nonMatching}
+
+	m.NotifyLogs([]LogEvent{{Log: types.Log{Address: addr}}})
+
+	if len(matching.results) != 1 {
+		t.Fatalf("got %d notifications on the matching sink, want 1", len(matching.results))
+	}
+	if len(nonMatching.results) != 0 {
+		t.Fatalf("got %d notifications on the non-matching sink, want 0", len(nonMatching.results))
+	}
+}
+
+func TestSubscribeAssertionsDeliversOverChannelAndCancels(t *testing.T) {
+	m := newSubscriptionManager()
+
+	ch, cancel, err := m.SubscribeAssertions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.subs) != 1 {
+		t.Fatalf("got %d subscriptions registered, want 1", len(m.subs))
+	}
+
+	m.NotifyNewAssertion(GetAssertionCountReply{})
+
+	select {
+	case result := <-ch:
+		if _, ok := result.(GetAssertionCountReply); !ok {
+			t.Fatalf("got result of type %T, want GetAssertionCountReply", result)
+		}
+	default:
+		t.Fatal("expected a notification on the channel")
+	}
+
+	cancel()
+	if len(m.subs) != 0 {
+		t.Fatalf("got %d subscriptions remaining after cancel, want 0", len(m.subs))
+	}
+}
+
+func TestFindLogsArgsMatches(t *testing.T) {
+	addr := common.HexToAddress("0x1")
+	otherAddr := common.HexToAddress("0x2")
+	topic := common.HexToHash("0xaa")
+	otherTopic := common.HexToHash("0xbb")
+
+	log := types.Log{
+		Address:     addr,
+		Topics:      []common.Hash{topic},
+		BlockNumber: 100,
+	}
+
+	tests := []struct {
+		name string
+		args *FindLogsArgs
+		want bool
+	}{
+		{"empty filter matches everything", &FindLogsArgs{}, true},
+		{"matching address", &FindLogsArgs{Address: []common.Address{addr}}, true},
+		{"non-matching address", &FindLogsArgs{Address: []common.Address{otherAddr}}, false},
+		{"matching topic", &FindLogsArgs{Topics: [][]common.Hash{{topic}}}, true},
+		{"non-matching topic", &FindLogsArgs{Topics: [][]common.Hash{{otherTopic}}}, false},
+		{"within height range", &FindLogsArgs{FromHeight: big.NewInt(50), ToHeight: big.NewInt(150)}, true},
+		{"below FromHeight", &FindLogsArgs{FromHeight: big.NewInt(200)}, false},
+		{"above ToHeight", &FindLogsArgs{ToHeight: big.NewInt(50)}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.args.Matches(log); got != tc.want {
+				t.Errorf("Matches() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}